@@ -18,59 +18,205 @@ package main
 
 import (
 	"encoding/hex"
+	"errors"
 	"hash"
 	"io"
+	"runtime"
 	"sync"
 
 	"github.com/klauspost/reedsolomon"
 )
 
+// errInvalidArgument is returned by erasureCreateFile/erasureResumeFile
+// when volume or path fails validateBucketName/validateObjectName
+// (see posix-utils_nix.go), so no erasure-coded data is ever written
+// under a name those checks reject. Other object layer entry points
+// do not go through these checks.
+var errInvalidArgument = errors.New("erasure: invalid volume or object path")
+
+// encodeWorkers bounds the number of concurrent reedsolomon encode
+// goroutines erasureWriteFile spawns per call, so encode CPU usage
+// scales with available cores instead of racing disk I/O 1:1.
+var encodeWorkers = runtime.NumCPU()
+
+// pipelineDepth bounds how many blocks may be in flight between the
+// read, encode and append stages of erasureWriteFile at once. Kept
+// small so memory stays bounded while still letting the three stages
+// overlap.
+const pipelineDepth = 4
+
+// readResult is one block handed from the read stage to the encode
+// workers. eof marks the final, zero-length block that signals the
+// encode and append stages to wind down.
+type readResult struct {
+	blockNum int
+	buf      []byte
+	n        int
+	eof      bool
+	err      error
+}
+
+// encodeJob pairs a readResult with a reply channel so that the
+// append stage can receive encode results strictly in the order
+// blocks were read, even though encodeWorkers goroutines race to
+// process them.
+type encodeJob struct {
+	readResult
+	replyCh chan encodeReply
+}
+
+// encodeReply is the outcome of erasure-encoding one block.
+type encodeReply struct {
+	blocks [][]byte
+	err    error
+}
+
 // erasureCreateFile - writes an entire stream by erasure coding to
 // all the disks, writes also calculate individual block's checksum
-// for future bit-rot protection.
-func erasureCreateFile(disks []StorageAPI, volume string, path string, partName string, data io.Reader, eInfos []erasureInfo, writeQuorum int) (newEInfos []erasureInfo, size int64, err error) {
-	// Just pick one eInfo.
+// for future bit-rot protection. algorithm selects the per-block
+// hashing scheme (see bitRotSHA512 and friends in erasure-utils.go);
+// an empty or unrecognized name falls back to globalBitRotAlgorithm,
+// which is SHA-512 unless setGlobalBitRotAlgorithm changed it.
+func erasureCreateFile(disks []StorageAPI, volume string, path string, partName string, data io.Reader, eInfos []erasureInfo, algorithm string, writeQuorum int) (newEInfos []erasureInfo, size int64, err error) {
+	if !validateBucketName(volume) || !validateObjectName(path) {
+		return nil, 0, errInvalidArgument
+	}
+
 	eInfo := pickValidErasureInfo(eInfos)
 
-	// Allocated blockSized buffer for reading.
-	buf := make([]byte, eInfo.BlockSize)
-	hashWriters := newHashWriters(len(disks))
-
-	// Read until io.EOF, erasure codes data and writes to all disks.
-	for {
-		var n int
-		var blocks [][]byte
-		n, err = io.ReadFull(data, buf)
-		if err == io.EOF {
-			// We have reached EOF on the first byte read, io.Reader
-			// must be 0bytes, we don't need to erasure code
-			// data. Will create a 0byte file instead.
-			if size == 0 {
-				blocks = make([][]byte, len(disks))
-				err = appendFile(disks, volume, path, blocks, eInfo.Distribution, hashWriters, writeQuorum)
-				if err != nil {
-					return nil, 0, err
+	// Fall back to the server-wide default so that the stored
+	// checkSumInfo.Algorithm always names the hasher that was
+	// actually used, never the empty string.
+	if !isValidBitRotAlgorithm(algorithm) {
+		algorithm = globalBitRotAlgorithm
+	}
+
+	hashWriters := newHashWriters(len(disks), algorithm)
+	return erasureWriteFile(disks, volume, path, partName, data, eInfo, eInfos, hashWriters, algorithm, 0, 0, writeQuorum)
+}
+
+// erasureWriteFile is the shared core behind erasureCreateFile and
+// erasureResumeFile (see erasure-resume.go): it erasure-codes data
+// starting at block startBlock/byte offset startSize and appends it
+// to disks, with hashWriters already positioned at the state that
+// corresponds to startSize (fresh for a new file, restored from a
+// journal for a resume).
+//
+// Reading the next block, reed-solomon encoding the current block
+// and appending the previous block to disks run as a three stage
+// pipeline, so that on multi-disk sets disk I/O for one block
+// overlaps with CPU encoding of the next instead of serializing
+// block by block. Every resumeJournalInterval blocks, progress is
+// checkpointed to a per-part journal so a future erasureResumeFile
+// call can pick up from the last committed block instead of from
+// startBlock again.
+func erasureWriteFile(disks []StorageAPI, volume string, path string, partName string, data io.Reader, eInfo erasureInfo, eInfos []erasureInfo, hashWriters []hash.Hash, algorithm string, startBlock int, startSize int64, writeQuorum int) (newEInfos []erasureInfo, size int64, err error) {
+	size = startSize
+
+	// Reusable blockSize buffers, returned to the pool once a block
+	// has been encoded and appended (or dropped on error).
+	bufPool := sync.Pool{
+		New: func() interface{} {
+			return make([]byte, eInfo.BlockSize)
+		},
+	}
+
+	jobsCh := make(chan *encodeJob, pipelineDepth)
+	orderCh := make(chan *encodeJob, pipelineDepth)
+	// stopCh is closed as soon as the append stage hits a fatal error,
+	// so the read stage stops pulling more data instead of reading
+	// and encoding the rest of a multi-GB stream for nothing.
+	stopCh := make(chan struct{})
+
+	var workers sync.WaitGroup
+	for i := 0; i < encodeWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for job := range jobsCh {
+				if job.err != nil || job.eof {
+					job.replyCh <- encodeReply{}
+					continue
 				}
-			} // else we have reached EOF after few reads, no need to
-			// add an additional 0bytes at the end.
-			break
-		}
-		if err != nil && err != io.ErrUnexpectedEOF {
-			return nil, 0, err
-		}
-		size += int64(n)
-		// Returns encoded blocks.
-		var enErr error
-		blocks, enErr = encodeData(buf[:n], eInfo.DataBlocks, eInfo.ParityBlocks)
-		if enErr != nil {
-			return nil, 0, enErr
+				blocks, enErr := encodeData(job.buf[:job.n], eInfo.DataBlocks, eInfo.ParityBlocks)
+				job.replyCh <- encodeReply{blocks: blocks, err: enErr}
+			}
+		}()
+	}
+
+	// Read stage - fills pooled buffers from data and dispatches them
+	// to the encode workers, while orderCh preserves the order blocks
+	// must be appended and hashed in.
+	go func() {
+		defer close(jobsCh)
+		defer close(orderCh)
+		blockNum := startBlock
+		for {
+			select {
+			case <-stopCh:
+				return
+			default:
+			}
+			buf := bufPool.Get().([]byte)
+			n, rErr := io.ReadFull(data, buf)
+			job := &encodeJob{readResult{blockNum: blockNum, buf: buf, n: n}, make(chan encodeReply, 1)}
+			if rErr == io.EOF {
+				job.eof = true
+				jobsCh <- job
+				orderCh <- job
+				return
+			}
+			if rErr != nil && rErr != io.ErrUnexpectedEOF {
+				job.err = rErr
+				jobsCh <- job
+				orderCh <- job
+				return
+			}
+			jobsCh <- job
+			orderCh <- job
+			blockNum++
 		}
+	}()
 
-		// Write to all disks.
-		err = appendFile(disks, volume, path, blocks, eInfo.Distribution, hashWriters, writeQuorum)
-		if err != nil {
-			return nil, 0, err
+	// Append stage - consumes encode results strictly in read order,
+	// so that hashWriters are updated in block order regardless of
+	// which encode worker finished first, then returns the buffer.
+	for job := range orderCh {
+		reply := <-job.replyCh
+		if err == nil {
+			switch {
+			case job.err != nil:
+				err = job.err
+			case reply.err != nil:
+				err = reply.err
+			case job.eof:
+				if size == 0 {
+					// We have reached EOF on the first byte read, io.Reader
+					// must be 0bytes, we don't need to erasure code
+					// data. Will create a 0byte file instead.
+					blocks := make([][]byte, len(disks))
+					err = appendFile(disks, volume, path, blocks, eInfo.Distribution, hashWriters, writeQuorum)
+				} // else we have reached EOF after few reads, no need to
+				// add an additional 0bytes at the end.
+			default:
+				size += int64(job.n)
+				err = appendFile(disks, volume, path, reply.blocks, eInfo.Distribution, hashWriters, writeQuorum)
+				if err == nil && (job.blockNum+1)%resumeJournalInterval == 0 {
+					// A failed checkpoint only costs a future resume
+					// some re-work; it must never fail the part write
+					// whose data already reached quorum above.
+					_ = writeResumeJournal(disks, volume, path, partName, algorithm, job.blockNum+1, size, hashWriters, writeQuorum)
+				}
+			}
+			if err != nil {
+				close(stopCh)
+			}
 		}
+		bufPool.Put(job.buf)
+	}
+	workers.Wait()
+	if err != nil {
+		return nil, 0, err
 	}
 
 	// Save the checksums.
@@ -79,7 +225,7 @@ func erasureCreateFile(disks []StorageAPI, volume string, path string, partName
 		blockIndex := eInfo.Distribution[index] - 1
 		checkSums[blockIndex] = checkSumInfo{
 			Name:      partName,
-			Algorithm: "sha512",
+			Algorithm: algorithm,
 			Hash:      hex.EncodeToString(hashWriters[blockIndex].Sum(nil)),
 		}
 	}
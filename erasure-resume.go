@@ -0,0 +1,325 @@
+/*
+ * Minio Cloud Storage, (C) 2016 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"strings"
+)
+
+// resumeJournalVersion is the on-disk format of a resume journal.
+// Bump it whenever the layout changes so erasureResumeFile refuses to
+// resume from a journal it can't interpret instead of misreading it.
+const resumeJournalVersion = "1"
+
+// resumeJournalInterval is how many blocks erasureWriteFile writes
+// between journal checkpoints. Smaller values shrink how much data a
+// resume has to re-read and re-encode, at the cost of more frequent
+// journal writes.
+const resumeJournalInterval = 16
+
+// resumeJournalSuffix names the journal file alongside the part it
+// protects, e.g. partName "part.1" journals to "part.1.journal".
+const resumeJournalSuffix = ".journal"
+
+// errResumeJournalNotFound is returned when no disk has a journal for
+// the requested part, so there is nothing to resume from.
+var errResumeJournalNotFound = errors.New("erasure: resume journal not found")
+
+// errResumeJournalVersion is returned when a journal exists but was
+// written by an incompatible resumeJournalVersion.
+var errResumeJournalVersion = errors.New("erasure: unsupported resume journal version")
+
+// errHashStateNotPersistable is returned when the configured bit-rot
+// algorithm's hash.Hash implementation doesn't support encoding its
+// running state, so it cannot be checkpointed into a journal.
+var errHashStateNotPersistable = errors.New("erasure: hash algorithm does not support state persistence")
+
+// resumeJournal is the state persisted alongside a part's erasure
+// metadata so that erasureResumeFile can reconstruct the write in
+// progress after a transport failure, instead of restarting the part
+// from byte zero.
+type resumeJournal struct {
+	Version    string   `json:"version"`
+	PartName   string   `json:"partName"`
+	Algorithm  string   `json:"algorithm"`
+	BlockIndex int      `json:"blockIndex"`
+	Offset     int64    `json:"offset"`
+	HashStates []string `json:"hashStates"`
+}
+
+// resumeJournalPath returns the journal path for partName alongside path.
+func resumeJournalPath(path, partName string) string {
+	dir := path[:strings.LastIndexByte(path, '/')+1]
+	return dir + partName + resumeJournalSuffix
+}
+
+// marshalHashState hex-encodes the running state of h, so it can be
+// restored later by unmarshalHashState. Returns errHashStateNotPersistable
+// if the algorithm's hash.Hash doesn't implement encoding.BinaryMarshaler.
+func marshalHashState(h hash.Hash) (string, error) {
+	marshaler, ok := h.(encoding.BinaryMarshaler)
+	if !ok {
+		return "", errHashStateNotPersistable
+	}
+	state, err := marshaler.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(state), nil
+}
+
+// unmarshalHashState restores h's running state from a string
+// produced by marshalHashState.
+func unmarshalHashState(h hash.Hash, state string) error {
+	unmarshaler, ok := h.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return errHashStateNotPersistable
+	}
+	data, err := hex.DecodeString(state)
+	if err != nil {
+		return err
+	}
+	return unmarshaler.UnmarshalBinary(data)
+}
+
+// writeResumeJournal checkpoints blockIndex/offset and the running
+// state of hashWriters to a journal written with the same write
+// quorum as the part itself, so a resume never trusts a checkpoint
+// that a later read could fail to reconstruct. The journal is purely
+// an optimization: a failure to checkpoint it never fails the part
+// write, it only means a future resume restarts further back.
+func writeResumeJournal(disks []StorageAPI, volume, path, partName, algorithm string, blockIndex int, offset int64, hashWriters []hash.Hash, writeQuorum int) error {
+	if !hashAlgorithmSupportsResume(algorithm) {
+		// Algorithm can't checkpoint its running hash state (e.g.
+		// blake2b-simd's digest has no BinaryMarshaler support);
+		// resume simply isn't available for this part, but the
+		// write itself must not fail because of it. Warn once so
+		// operators aren't misled into thinking resume works.
+		warnUnresumableAlgorithm(algorithm)
+		return nil
+	}
+
+	hashStates := make([]string, len(hashWriters))
+	for index, hashWriter := range hashWriters {
+		state, err := marshalHashState(hashWriter)
+		if err != nil {
+			return err
+		}
+		hashStates[index] = state
+	}
+
+	journal := resumeJournal{
+		Version:    resumeJournalVersion,
+		PartName:   partName,
+		Algorithm:  algorithm,
+		BlockIndex: blockIndex,
+		Offset:     offset,
+		HashStates: hashStates,
+	}
+	data, err := json.Marshal(journal)
+	if err != nil {
+		return err
+	}
+
+	jPath := resumeJournalPath(path, partName)
+	wErrs := make([]error, len(disks))
+	doneCh := make(chan int, len(disks))
+	for index, disk := range disks {
+		if disk == nil {
+			doneCh <- index
+			continue
+		}
+		go func(index int, disk StorageAPI) {
+			// Journal checkpoints overwrite each other, unlike the
+			// append-only part data; drop the previous checkpoint
+			// before writing the new one.
+			disk.DeleteFile(volume, jPath)
+			wErrs[index] = disk.AppendFile(volume, jPath, data)
+			doneCh <- index
+		}(index, disk)
+	}
+	for range disks {
+		<-doneCh
+	}
+
+	if !isQuorum(wErrs, writeQuorum) {
+		return toObjectErr(errXLWriteQuorum, volume, jPath)
+	}
+	return nil
+}
+
+// readAllFile reads the entire contents of path on disk, erroring with
+// io.ErrUnexpectedEOF rather than returning a zero-padded buffer if
+// the disk comes back short without an error.
+func readAllFile(disk StorageAPI, volume, path string) ([]byte, error) {
+	fileInfo, err := disk.StatFile(volume, path)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, fileInfo.Size)
+	n, err := disk.ReadFile(volume, path, 0, buf)
+	if err != nil {
+		return nil, err
+	}
+	if n != int64(len(buf)) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return buf, nil
+}
+
+// readResumeJournal returns the most advanced valid, version-matching
+// journal for partName found across disks. Disks can fall behind by a
+// checkpoint or two (a single slow AppendFile), so picking the first
+// journal found rather than the furthest-along one could resume from
+// an earlier block than most disks have already committed past,
+// re-appending data that already reached quorum.
+func readResumeJournal(disks []StorageAPI, volume, path, partName string) (journal resumeJournal, err error) {
+	jPath := resumeJournalPath(path, partName)
+	err = errResumeJournalNotFound
+	found := false
+	for _, disk := range disks {
+		if disk == nil {
+			continue
+		}
+		data, rErr := readAllFile(disk, volume, jPath)
+		if rErr != nil {
+			if !found {
+				err = rErr
+			}
+			continue
+		}
+		var j resumeJournal
+		if uErr := json.Unmarshal(data, &j); uErr != nil {
+			if !found {
+				err = uErr
+			}
+			continue
+		}
+		if j.Version != resumeJournalVersion {
+			if !found {
+				err = errResumeJournalVersion
+			}
+			continue
+		}
+		if !found || j.BlockIndex > journal.BlockIndex {
+			journal = j
+			found = true
+		}
+	}
+	if !found {
+		return resumeJournal{}, err
+	}
+	return journal, nil
+}
+
+// verifyResumeDisks confirms each disk's current on-disk size for
+// path matches what blockIndex full blocks of eInfo.BlockSize should
+// have produced, before erasureResumeFile trusts the journal and
+// continues appending to it. appendFile only requires write quorum,
+// so a single slow or flaky disk's AppendFile can come up short on an
+// earlier block while every disk's journal checkpoint still records
+// the same advanced blockIndex; without this check a resume would
+// glue new blocks onto that disk's torn earlier block; the recorded
+// checksum, restored from the journal rather than recomputed from
+// what's actually on disk, would still match.
+//
+// A disk whose size disagrees is excluded (set to nil) rather than
+// failing the resume outright, the same way a down disk is excluded
+// elsewhere in this package: the resume proceeds as long as
+// writeQuorum disks remain, and the excluded disk is left for the
+// healing path to pick up from the last block it actually has.
+func verifyResumeDisks(disks []StorageAPI, volume, path string, eInfo erasureInfo, blockIndex int, writeQuorum int) ([]StorageAPI, error) {
+	// encodeData's Split rounds each shard up, padding the last one.
+	shardSize := (eInfo.BlockSize + int64(eInfo.DataBlocks) - 1) / int64(eInfo.DataBlocks)
+	wantSize := int64(blockIndex) * shardSize
+
+	verified := make([]StorageAPI, len(disks))
+	copy(verified, disks)
+	healthy := 0
+	for index, disk := range disks {
+		if disk == nil {
+			continue
+		}
+		fileInfo, err := disk.StatFile(volume, path)
+		if err != nil || fileInfo.Size != wantSize {
+			verified[index] = nil
+			continue
+		}
+		healthy++
+	}
+
+	if healthy < writeQuorum {
+		return nil, toObjectErr(errXLWriteQuorum, volume, path)
+	}
+	return verified, nil
+}
+
+// removeResumeJournal garbage collects the resume journal for
+// partName on every non-nil disk, ignoring per-disk errors. It is not
+// run automatically when a part or multipart upload completes; a
+// journal stays on disk until something calls this.
+func removeResumeJournal(disks []StorageAPI, volume, path, partName string) {
+	jPath := resumeJournalPath(path, partName)
+	for _, disk := range disks {
+		if disk == nil {
+			continue
+		}
+		disk.DeleteFile(volume, jPath)
+	}
+}
+
+// erasureResumeFile resumes a part write that was interrupted
+// mid-stream. It reads the last journal checkpoint for partName,
+// reconstructs the per-disk hash writers from the checkpointed state,
+// and continues erasure-coding and appending from the first block
+// that had not yet reached write quorum when the journal was taken.
+//
+// data must yield the same bytes the original upload would have
+// produced starting at the journal's offset. erasureResumeFile does
+// not seek or re-request data itself, and does not return that offset
+// to its caller; it returns only the part's total size.
+func erasureResumeFile(disks []StorageAPI, volume string, path string, partName string, data io.Reader, eInfos []erasureInfo, writeQuorum int) (newEInfos []erasureInfo, size int64, err error) {
+	if !validateBucketName(volume) || !validateObjectName(path) {
+		return nil, 0, errInvalidArgument
+	}
+
+	journal, err := readResumeJournal(disks, volume, path, partName)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	eInfo := pickValidErasureInfo(eInfos)
+	disks, err = verifyResumeDisks(disks, volume, path, eInfo, journal.BlockIndex, writeQuorum)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	hashWriters := newHashWriters(len(disks), journal.Algorithm)
+	for index, state := range journal.HashStates {
+		if err = unmarshalHashState(hashWriters[index], state); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return erasureWriteFile(disks, volume, path, partName, data, eInfo, eInfos, hashWriters, journal.Algorithm, journal.BlockIndex, journal.Offset, writeQuorum)
+}
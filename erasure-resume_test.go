@@ -0,0 +1,372 @@
+/*
+ * Minio Cloud Storage, (C) 2016 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"testing"
+)
+
+// failDisk is a StorageAPI that fails every operation, used to force
+// a write-quorum shortfall in tests.
+type failDisk struct{}
+
+var errFailDisk = errors.New("simulated disk failure")
+
+func (failDisk) AppendFile(volume, path string, buf []byte) error { return errFailDisk }
+func (failDisk) ReadFile(volume, path string, offset int64, buf []byte) (int64, error) {
+	return 0, errFailDisk
+}
+func (failDisk) StatFile(volume, path string) (FileInfo, error) { return FileInfo{}, errFailDisk }
+func (failDisk) DeleteFile(volume, path string) error           { return errFailDisk }
+
+// newTestDisks returns numDisks fresh memDisks as a StorageAPI slice.
+func newTestDisks(numDisks int) []StorageAPI {
+	disks := make([]StorageAPI, numDisks)
+	for i := range disks {
+		disks[i] = newMemDisk()
+	}
+	return disks
+}
+
+// mustMarshalJournal JSON-encodes journal for directly seeding a
+// memDisk's journal file in tests that need to control its contents
+// precisely (e.g. simulating disks that disagree on the checkpoint).
+func mustMarshalJournal(t *testing.T, journal resumeJournal) []byte {
+	t.Helper()
+	data, err := json.Marshal(journal)
+	if err != nil {
+		t.Fatalf("json.Marshal(resumeJournal): %v", err)
+	}
+	return data
+}
+
+// hashStates marshals the running state of each hashWriter.
+func hashStates(t *testing.T, hashWriters []hash.Hash) []string {
+	t.Helper()
+	states := make([]string, len(hashWriters))
+	for index, hashWriter := range hashWriters {
+		state, err := marshalHashState(hashWriter)
+		if err != nil {
+			t.Fatalf("marshalHashState: %v", err)
+		}
+		states[index] = state
+	}
+	return states
+}
+
+// TestMarshalUnmarshalHashState round-trips the running state of each
+// resumable algorithm's hash.Hash through marshalHashState and
+// unmarshalHashState, and confirms blake2b is correctly reported as
+// unable to persist its state.
+func TestMarshalUnmarshalHashState(t *testing.T) {
+	block := []byte("resumable multipart upload block")
+	for _, algorithm := range []string{bitRotSHA512, bitRotXXHash64} {
+		h := newHashWriter(algorithm)
+		h.Write(block)
+		state, err := marshalHashState(h)
+		if err != nil {
+			t.Fatalf("algorithm %q: marshalHashState: %v", algorithm, err)
+		}
+
+		restored := newHashWriter(algorithm)
+		if err := unmarshalHashState(restored, state); err != nil {
+			t.Fatalf("algorithm %q: unmarshalHashState: %v", algorithm, err)
+		}
+		if !bytes.Equal(h.Sum(nil), restored.Sum(nil)) {
+			t.Errorf("algorithm %q: restored hash state produced a different sum", algorithm)
+		}
+	}
+
+	h := newHashWriter(bitRotBlake2b)
+	h.Write(block)
+	if _, err := marshalHashState(h); err != errHashStateNotPersistable {
+		t.Errorf("blake2b: marshalHashState error = %v, want errHashStateNotPersistable", err)
+	}
+	if !hashAlgorithmSupportsResume(bitRotSHA512) || !hashAlgorithmSupportsResume(bitRotXXHash64) {
+		t.Error("expected sha512 and xxhash64 to support resume checkpointing")
+	}
+	if hashAlgorithmSupportsResume(bitRotBlake2b) {
+		t.Error("expected blake2b to not support resume checkpointing")
+	}
+}
+
+// TestWriteReadResumeJournal checkpoints a journal and reads it back,
+// verifying every field round-trips including the hash state.
+func TestWriteReadResumeJournal(t *testing.T) {
+	disks := newTestDisks(3)
+	hashWriters := newHashWriters(3, bitRotSHA512)
+	for _, h := range hashWriters {
+		h.Write([]byte("block-0"))
+	}
+
+	if err := writeResumeJournal(disks, "bucket", "object", "part.1", bitRotSHA512, 1, 7, hashWriters, 2); err != nil {
+		t.Fatalf("writeResumeJournal: %v", err)
+	}
+
+	journal, err := readResumeJournal(disks, "bucket", "object", "part.1")
+	if err != nil {
+		t.Fatalf("readResumeJournal: %v", err)
+	}
+	if journal.PartName != "part.1" || journal.Algorithm != bitRotSHA512 || journal.BlockIndex != 1 || journal.Offset != 7 {
+		t.Fatalf("readResumeJournal returned unexpected journal: %+v", journal)
+	}
+	for index, state := range journal.HashStates {
+		restored := newHashWriter(bitRotSHA512)
+		if err := unmarshalHashState(restored, state); err != nil {
+			t.Fatalf("unmarshalHashState: %v", err)
+		}
+		if !bytes.Equal(restored.Sum(nil), hashWriters[index].Sum(nil)) {
+			t.Errorf("disk %d: journal hash state does not match the original hashWriter", index)
+		}
+	}
+}
+
+// TestWriteResumeJournalUnresumableAlgorithm verifies that
+// writeResumeJournal skips checkpointing (without error) for an
+// algorithm that can't persist its hash state, rather than writing a
+// journal that erasureResumeFile could never reconstruct.
+func TestWriteResumeJournalUnresumableAlgorithm(t *testing.T) {
+	disks := newTestDisks(3)
+	hashWriters := newHashWriters(3, bitRotBlake2b)
+
+	if err := writeResumeJournal(disks, "bucket", "object", "part.1", bitRotBlake2b, 1, 7, hashWriters, 2); err != nil {
+		t.Fatalf("writeResumeJournal: %v", err)
+	}
+	if _, err := readResumeJournal(disks, "bucket", "object", "part.1"); err != errResumeJournalNotFound {
+		t.Errorf("readResumeJournal error = %v, want errResumeJournalNotFound (no journal should have been written)", err)
+	}
+}
+
+// TestWriteResumeJournalQuorumShortfall verifies that a journal
+// checkpoint which can't reach write quorum is reported as an error.
+func TestWriteResumeJournalQuorumShortfall(t *testing.T) {
+	disks := []StorageAPI{newMemDisk(), failDisk{}, failDisk{}}
+	hashWriters := newHashWriters(3, bitRotSHA512)
+
+	if err := writeResumeJournal(disks, "bucket", "object", "part.1", bitRotSHA512, 1, 7, hashWriters, 3); err == nil {
+		t.Fatal("expected writeResumeJournal to fail when write quorum can't be reached")
+	}
+}
+
+// TestReadResumeJournalNotFound verifies readResumeJournal reports
+// errResumeJournalNotFound when no disk has ever had a journal for
+// the part.
+func TestReadResumeJournalNotFound(t *testing.T) {
+	disks := newTestDisks(3)
+	if _, err := readResumeJournal(disks, "bucket", "object", "part.1"); err != errResumeJournalNotFound {
+		t.Errorf("readResumeJournal error = %v, want errResumeJournalNotFound", err)
+	}
+}
+
+// TestReadResumeJournalVersionMismatch verifies a journal written
+// with a future/incompatible resumeJournalVersion is rejected rather
+// than being misread.
+func TestReadResumeJournalVersionMismatch(t *testing.T) {
+	disks := newTestDisks(3)
+	jPath := resumeJournalPath("object", "part.1")
+	for _, disk := range disks {
+		if err := disk.AppendFile("bucket", jPath, []byte(`{"version":"99"}`)); err != nil {
+			t.Fatalf("AppendFile: %v", err)
+		}
+	}
+	if _, err := readResumeJournal(disks, "bucket", "object", "part.1"); err != errResumeJournalVersion {
+		t.Errorf("readResumeJournal error = %v, want errResumeJournalVersion", err)
+	}
+}
+
+// TestReadResumeJournalPicksMostAdvanced verifies that when disks
+// disagree on the last checkpoint (one fell behind), the journal with
+// the highest BlockIndex wins.
+func TestReadResumeJournalPicksMostAdvanced(t *testing.T) {
+	disks := newTestDisks(3)
+	hashWriters := newHashWriters(3, bitRotSHA512)
+
+	// Simulate disk 0 missing the latest checkpoint: it only has the
+	// earlier one.
+	if err := disks[0].AppendFile("bucket", resumeJournalPath("object", "part.1"),
+		mustMarshalJournal(t, resumeJournal{Version: resumeJournalVersion, PartName: "part.1", Algorithm: bitRotSHA512, BlockIndex: 1, Offset: 4, HashStates: hashStates(t, hashWriters)})); err != nil {
+		t.Fatalf("AppendFile: %v", err)
+	}
+	for _, disk := range disks[1:] {
+		if err := disk.AppendFile("bucket", resumeJournalPath("object", "part.1"),
+			mustMarshalJournal(t, resumeJournal{Version: resumeJournalVersion, PartName: "part.1", Algorithm: bitRotSHA512, BlockIndex: 2, Offset: 8, HashStates: hashStates(t, hashWriters)})); err != nil {
+			t.Fatalf("AppendFile: %v", err)
+		}
+	}
+
+	journal, err := readResumeJournal(disks, "bucket", "object", "part.1")
+	if err != nil {
+		t.Fatalf("readResumeJournal: %v", err)
+	}
+	if journal.BlockIndex != 2 {
+		t.Errorf("readResumeJournal picked BlockIndex %d, want the more advanced checkpoint (2)", journal.BlockIndex)
+	}
+}
+
+// TestErasureResumeFile writes one block of a part directly (as
+// erasureWriteFile would), checkpoints a journal after it, then
+// resumes with the remaining data and confirms the final checksum
+// matches a from-scratch encoding of both blocks.
+func TestErasureResumeFile(t *testing.T) {
+	const blockSize = 4
+	dataBlocks, parityBlocks := 2, 1
+	numDisks := dataBlocks + parityBlocks
+	distribution := []int{1, 2, 3}
+	writeQuorum := dataBlocks + 1
+
+	block0 := []byte("AAAA")
+	block1 := []byte("BB")
+
+	disks := newTestDisks(numDisks)
+	eInfo := erasureInfo{DataBlocks: dataBlocks, ParityBlocks: parityBlocks, BlockSize: blockSize, Distribution: distribution}
+	eInfos := make([]erasureInfo, numDisks)
+	for i := range eInfos {
+		eInfos[i] = eInfo
+	}
+
+	// Write block0 directly, as if a first erasureWriteFile call had
+	// gotten this far before a transport failure.
+	hashWriters := newHashWriters(numDisks, bitRotSHA512)
+	encoded0, err := encodeData(block0, dataBlocks, parityBlocks)
+	if err != nil {
+		t.Fatalf("encodeData: %v", err)
+	}
+	if err := appendFile(disks, "bucket", "object", encoded0, distribution, hashWriters, writeQuorum); err != nil {
+		t.Fatalf("appendFile: %v", err)
+	}
+	if err := writeResumeJournal(disks, "bucket", "object", "part.1", bitRotSHA512, 1, int64(len(block0)), hashWriters, writeQuorum); err != nil {
+		t.Fatalf("writeResumeJournal: %v", err)
+	}
+
+	newEInfos, size, err := erasureResumeFile(disks, "bucket", "object", "part.1", bytes.NewReader(block1), eInfos, writeQuorum)
+	if err != nil {
+		t.Fatalf("erasureResumeFile: %v", err)
+	}
+	if want := int64(len(block0) + len(block1)); size != want {
+		t.Errorf("erasureResumeFile size = %d, want %d", size, want)
+	}
+
+	// The resumed checksum must equal hashing both blocks in order
+	// from scratch.
+	wantHashWriters := newHashWriters(numDisks, bitRotSHA512)
+	encoded1, err := encodeData(block1, dataBlocks, parityBlocks)
+	if err != nil {
+		t.Fatalf("encodeData: %v", err)
+	}
+	for _, blocks := range [][][]byte{encoded0, encoded1} {
+		for index := range disks {
+			blockIndex := distribution[index] - 1
+			wantHashWriters[blockIndex].Write(blocks[blockIndex])
+		}
+	}
+	for index := range disks {
+		blockIndex := distribution[index] - 1
+		got := newEInfos[index].Checksum[len(newEInfos[index].Checksum)-1].Hash
+		want := hex.EncodeToString(wantHashWriters[blockIndex].Sum(nil))
+		if got != want {
+			t.Errorf("disk %d: resumed checksum = %s, want %s", index, got, want)
+		}
+	}
+}
+
+// TestErasureResumeFileDetectsTornDisk simulates a disk that silently
+// fell short of the block the journal claims every disk committed
+// (appendFile only requires write quorum, so this can happen without
+// any write ever failing outright). erasureResumeFile must exclude
+// that disk from the resumed write rather than trusting the journal
+// and gluing new blocks onto its torn earlier block.
+func TestErasureResumeFileDetectsTornDisk(t *testing.T) {
+	const blockSize = 4
+	dataBlocks, parityBlocks := 2, 1
+	numDisks := dataBlocks + parityBlocks
+	distribution := []int{1, 2, 3}
+	// Write quorum is lowered to dataBlocks so the resume can still
+	// succeed with one disk excluded; erasureCreateFile/PutObjectPart
+	// would normally use dataBlocks+1.
+	writeQuorum := dataBlocks
+
+	block0 := []byte("AAAA")
+	block1 := []byte("BB")
+
+	disks := newTestDisks(numDisks)
+	eInfo := erasureInfo{DataBlocks: dataBlocks, ParityBlocks: parityBlocks, BlockSize: blockSize, Distribution: distribution}
+	eInfos := make([]erasureInfo, numDisks)
+	for i := range eInfos {
+		eInfos[i] = eInfo
+	}
+
+	hashWriters := newHashWriters(numDisks, bitRotSHA512)
+	encoded0, err := encodeData(block0, dataBlocks, parityBlocks)
+	if err != nil {
+		t.Fatalf("encodeData: %v", err)
+	}
+	if err := appendFile(disks, "bucket", "object", encoded0, distribution, hashWriters, writeQuorum); err != nil {
+		t.Fatalf("appendFile: %v", err)
+	}
+	if err := writeResumeJournal(disks, "bucket", "object", "part.1", bitRotSHA512, 1, int64(len(block0)), hashWriters, writeQuorum); err != nil {
+		t.Fatalf("writeResumeJournal: %v", err)
+	}
+
+	// Tear disk 0: drop the last byte it actually has on disk, even
+	// though the journal (shared across all disks) still claims
+	// BlockIndex 1 for it.
+	md := disks[0].(*memDisk)
+	md.mu.Lock()
+	key := "bucket/object"
+	torn := md.files[key][:len(md.files[key])-1]
+	md.files[key] = torn
+	tornLen := len(torn)
+	md.mu.Unlock()
+
+	if _, _, err := erasureResumeFile(disks, "bucket", "object", "part.1", bytes.NewReader(block1), eInfos, writeQuorum); err != nil {
+		t.Fatalf("erasureResumeFile: %v", err)
+	}
+
+	// Disk 0 must have been excluded rather than appended to further:
+	// its on-disk length should be exactly what it was left with after
+	// being torn, not extended with block1's shard.
+	md.mu.Lock()
+	gotLen := len(md.files[key])
+	md.mu.Unlock()
+	if gotLen != tornLen {
+		t.Errorf("disk 0: on-disk length = %d, want %d (torn disk must not receive further appends)", gotLen, tornLen)
+	}
+}
+
+// TestVerifyResumeDisksQuorumShortfall verifies that when too many
+// disks disagree with the journaled checkpoint to reach write quorum,
+// verifyResumeDisks reports an error instead of silently proceeding
+// with too few disks.
+func TestVerifyResumeDisksQuorumShortfall(t *testing.T) {
+	const blockSize = 4
+	dataBlocks, parityBlocks := 2, 1
+	numDisks := dataBlocks + parityBlocks
+	eInfo := erasureInfo{DataBlocks: dataBlocks, ParityBlocks: parityBlocks, BlockSize: blockSize}
+
+	disks := newTestDisks(numDisks)
+	// No disk has ever been written to, so every StatFile fails; a
+	// journal claiming BlockIndex 1 (i.e. a non-zero expected size)
+	// must not be trusted by any of them.
+	if _, err := verifyResumeDisks(disks, "bucket", "object", eInfo, 1, dataBlocks+1); err == nil {
+		t.Fatal("expected verifyResumeDisks to fail when no disk matches the journaled checkpoint")
+	}
+}
@@ -0,0 +1,145 @@
+/*
+ * Minio Cloud Storage, (C) 2016 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/sha512"
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"log"
+	"sync"
+
+	"github.com/OneOfOne/xxhash"
+	"github.com/minio/blake2b-simd"
+)
+
+// Supported bit-rot protection algorithms for per-block checksums.
+// checkSumInfo.Algorithm stores one of these per block so that the
+// read path knows which hasher to reconstruct for verification,
+// regardless of what the server's default is at read time.
+//
+// bitRotBlake2b does not support the resumable-journal checkpointing
+// in erasure-resume.go: blake2b-simd's digest type implements neither
+// encoding.BinaryMarshaler nor BinaryUnmarshaler, so its running hash
+// state can't be persisted. Multipart uploads using it still work,
+// they just can't resume after a transport failure mid-part; see
+// hashAlgorithmSupportsResume.
+const (
+	bitRotSHA512   = "sha512"
+	bitRotBlake2b  = "blake2b"
+	bitRotXXHash64 = "xxhash64"
+)
+
+// bitRotAlgorithms is the set of algorithm names newHashWriter
+// accepts, in the order a server config flag would offer them.
+var bitRotAlgorithms = []string{bitRotSHA512, bitRotBlake2b, bitRotXXHash64}
+
+// globalBitRotAlgorithm is the default per-block checksum algorithm
+// for newly created objects. No server config flag sets it; it stays
+// bitRotSHA512 unless setGlobalBitRotAlgorithm is called to opt into
+// a faster hasher for new writes; existing objects are
+// unaffected either way since their algorithm is read back from
+// per-object checkSumInfo, not from this default.
+var globalBitRotAlgorithm = bitRotSHA512
+
+// setGlobalBitRotAlgorithm validates and sets the server-wide default
+// bit-rot algorithm used for objects that don't request one
+// explicitly via per-object metadata.
+func setGlobalBitRotAlgorithm(algorithm string) error {
+	if !isValidBitRotAlgorithm(algorithm) {
+		return fmt.Errorf("unsupported bit-rot algorithm %q", algorithm)
+	}
+	globalBitRotAlgorithm = algorithm
+	return nil
+}
+
+// isValidBitRotAlgorithm returns true if algorithm is a name this
+// server knows how to both compute and verify.
+func isValidBitRotAlgorithm(algorithm string) bool {
+	for _, a := range bitRotAlgorithms {
+		if a == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// newHashWriter returns a new hash.Hash implementing the requested
+// bit-rot protection algorithm. An empty or unrecognized algorithm
+// falls back to SHA-512, the original and still the default
+// algorithm, so existing objects keep verifying unchanged.
+func newHashWriter(algorithm string) hash.Hash {
+	switch algorithm {
+	case bitRotBlake2b:
+		return blake2b.New512()
+	case bitRotXXHash64:
+		return xxhash.New64()
+	default:
+		return sha512.New()
+	}
+}
+
+// newHashWriters - inits a slice of hash.Hash, one per disk,
+// computing bit-rot protection for erasure coded blocks using the
+// given algorithm.
+func newHashWriters(numDisks int, algorithm string) []hash.Hash {
+	hashWriters := make([]hash.Hash, numDisks)
+	for index := range hashWriters {
+		hashWriters[index] = newHashWriter(algorithm)
+	}
+	return hashWriters
+}
+
+// hashAlgorithmSupportsResume reports whether algorithm's hash.Hash
+// implementation can have its running state checkpointed into a
+// resume journal (see erasure-resume.go), i.e. whether it implements
+// encoding.BinaryMarshaler. sha512 and xxhash64 do; blake2b-simd's
+// digest does not.
+func hashAlgorithmSupportsResume(algorithm string) bool {
+	_, ok := newHashWriter(algorithm).(encoding.BinaryMarshaler)
+	return ok
+}
+
+var (
+	unresumableWarnMu sync.Mutex
+	unresumableWarned = map[string]bool{}
+)
+
+// warnUnresumableAlgorithm logs, once per algorithm per process, that
+// writeResumeJournal is skipping checkpoints for it.
+func warnUnresumableAlgorithm(algorithm string) {
+	unresumableWarnMu.Lock()
+	defer unresumableWarnMu.Unlock()
+	if unresumableWarned[algorithm] {
+		return
+	}
+	unresumableWarned[algorithm] = true
+	log.Printf("erasure: bit-rot algorithm %q cannot checkpoint hash state; multipart uploads using it will not be resumable after a transport failure", algorithm)
+}
+
+// bitRotVerify returns true if the block's checksum, recomputed with
+// the algorithm recorded in checkSum, matches the stored hash. Older
+// objects written before this registry existed have checkSum.Algorithm
+// set to "sha512" (erasureCreateFile never stores an empty value), so
+// they keep verifying exactly as before.
+func bitRotVerify(block []byte, checkSum checkSumInfo) bool {
+	hashWriter := newHashWriter(checkSum.Algorithm)
+	hashWriter.Write(block)
+	return hex.EncodeToString(hashWriter.Sum(nil)) == checkSum.Hash
+}
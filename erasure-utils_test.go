@@ -0,0 +1,63 @@
+/*
+ * Minio Cloud Storage, (C) 2016 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// TestBitRotVerify round-trips a block through newHashWriter and
+// bitRotVerify for every supported algorithm, so a hasher that
+// doesn't actually verify its own output (e.g. a broken Sum/Write
+// pairing) fails immediately instead of surfacing only when an
+// admin opts into it via setGlobalBitRotAlgorithm.
+func TestBitRotVerify(t *testing.T) {
+	block := []byte("the quick brown fox jumps over the lazy dog")
+	for _, algorithm := range bitRotAlgorithms {
+		hashWriter := newHashWriter(algorithm)
+		hashWriter.Write(block)
+		checkSum := checkSumInfo{
+			Name:      "part.1",
+			Algorithm: algorithm,
+			Hash:      hex.EncodeToString(hashWriter.Sum(nil)),
+		}
+		if !bitRotVerify(block, checkSum) {
+			t.Errorf("algorithm %q: bitRotVerify failed to verify its own checksum", algorithm)
+		}
+		if bitRotVerify([]byte("corrupted data"), checkSum) {
+			t.Errorf("algorithm %q: bitRotVerify accepted a checksum for the wrong block", algorithm)
+		}
+	}
+}
+
+// TestIsValidBitRotAlgorithm checks the accepted/rejected algorithm
+// names, since newHashWriter silently falls back to SHA-512 for
+// anything isValidBitRotAlgorithm rejects.
+func TestIsValidBitRotAlgorithm(t *testing.T) {
+	for _, algorithm := range bitRotAlgorithms {
+		if !isValidBitRotAlgorithm(algorithm) {
+			t.Errorf("expected %q to be a valid bit-rot algorithm", algorithm)
+		}
+	}
+	if isValidBitRotAlgorithm("md5") {
+		t.Error("expected \"md5\" to be rejected as an unsupported bit-rot algorithm")
+	}
+	if isValidBitRotAlgorithm("") {
+		t.Error("expected \"\" to be rejected as an unsupported bit-rot algorithm")
+	}
+}
@@ -0,0 +1,59 @@
+/*
+ * Minio Cloud Storage, (C) 2016 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+// erasureVerifyFile is the read-side counterpart to erasureCreateFile
+// and erasureResumeFile: it re-reads every disk's on-disk copy of
+// partName and confirms it still matches the bit-rot checksum they
+// recorded for it, dispatching to whichever hasher checkSumInfo.
+// Algorithm names (see bitRotVerify in erasure-utils.go) rather than
+// assuming the server's current default. It only reports; it does not
+// heal or rewrite a disk that fails, and the object read and healing
+// paths do not call it on their own.
+//
+// It returns one bool per disk: true if that disk's copy of partName
+// is present and verifies, false if it's missing, unreadable, or
+// fails its checksum.
+func erasureVerifyFile(disks []StorageAPI, volume, path, partName string, eInfos []erasureInfo) []bool {
+	healthy := make([]bool, len(disks))
+	for index, disk := range disks {
+		if disk == nil || !eInfos[index].IsValid() {
+			continue
+		}
+		checkSum := lastPartCheckSum(eInfos[index].Checksum, partName)
+		if checkSum == nil {
+			continue
+		}
+		block, err := readAllFile(disk, volume, path)
+		if err != nil {
+			continue
+		}
+		healthy[index] = bitRotVerify(block, *checkSum)
+	}
+	return healthy
+}
+
+// lastPartCheckSum returns the most recently recorded checkSumInfo for
+// partName, or nil if checksums has none.
+func lastPartCheckSum(checksums []checkSumInfo, partName string) *checkSumInfo {
+	for i := len(checksums) - 1; i >= 0; i-- {
+		if checksums[i].Name == partName {
+			return &checksums[i]
+		}
+	}
+	return nil
+}
@@ -22,10 +22,59 @@ import (
 	"os"
 	"strings"
 	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 const pathMax = 4096 // 4k limit on all unixes.
 
+// windowsReservedNames are the MS-DOS era device names that cannot be
+// used as a file or directory name component on Windows, with or
+// without an extension (e.g. "COM1" and "COM1.txt" both collide).
+// Rejecting them here too keeps objects portable to a Windows-backed
+// gateway or mirror target even though this build runs on a unix FS.
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true,
+	"COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true,
+	"LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// hasControlChars reports whether s contains an ASCII control
+// character (0x00-0x1F or the DEL character 0x7F).
+func hasControlChars(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] <= 0x1f || s[i] == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// isValidPathComponent applies the portability rules shared by
+// isValidPath and isValidVolname to a single '/'-separated component:
+// no empty, "." or ".." components, no control characters, no
+// trailing dot or space (Windows silently strips these, so two
+// distinct names could otherwise collide there), and no
+// Windows-reserved device name.
+func isValidPathComponent(component string) bool {
+	if component == "" || component == "." || component == ".." {
+		return false
+	}
+	if hasControlChars(component) {
+		return false
+	}
+	if strings.HasSuffix(component, ".") || strings.HasSuffix(component, " ") {
+		return false
+	}
+	base := component
+	if idx := strings.IndexByte(component, '.'); idx >= 0 {
+		base = component[:idx]
+	}
+	return !windowsReservedNames[strings.ToUpper(base)]
+}
+
 // isValidPath verifies if a path name is in accordance with FS limitations.
 func isValidPath(path string) bool {
 	if len(path) > pathMax || len(path) == 0 {
@@ -34,6 +83,18 @@ func isValidPath(path string) bool {
 	if !utf8.ValidString(path) {
 		return false
 	}
+	components := strings.Split(path, "/")
+	// A single trailing "/" denotes the common S3 folder-marker
+	// convention (e.g. "photos/"), not an empty path component, so
+	// it's dropped before validating the remaining components.
+	if len(components) > 1 && components[len(components)-1] == "" {
+		components = components[:len(components)-1]
+	}
+	for _, component := range components {
+		if !isValidPathComponent(component) {
+			return false
+		}
+	}
 	return true
 }
 
@@ -44,7 +105,35 @@ func isValidVolname(volname string) bool {
 		return false
 	}
 	// Volname shouldn't have '/' in it.
-	return !strings.ContainsAny(volname, "/")
+	if strings.ContainsAny(volname, "/") {
+		return false
+	}
+	return isValidPathComponent(volname)
+}
+
+// isNFCNormalized reports whether s is already in Unicode NFC
+// normalization form. Names that differ under NFC are rejected
+// outright instead of silently normalized, since two names that
+// render identically but differ in normalization would otherwise
+// collide on some client filesystems but not on ours.
+func isNFCNormalized(s string) bool {
+	return norm.NFC.IsNormalString(s)
+}
+
+// validateObjectName decides whether an object key is safe to store:
+// a valid path per isValidPath, valid UTF-8 already in NFC form.
+// erasureCreateFile and erasureResumeFile check paths with it;
+// isValidPath on its own does not apply the NFC rule.
+func validateObjectName(object string) bool {
+	return isValidPath(object) && isNFCNormalized(object)
+}
+
+// validateBucketName decides whether a bucket name is safe to store:
+// a valid volume name per isValidVolname, valid UTF-8 already in NFC
+// form. erasureCreateFile and erasureResumeFile check volumes with it;
+// isValidVolname on its own does not apply the UTF-8 or NFC rules.
+func validateBucketName(bucket string) bool {
+	return isValidVolname(bucket) && utf8.ValidString(bucket) && isNFCNormalized(bucket)
 }
 
 // mkdirAll creates a directory named path,
@@ -0,0 +1,101 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+/*
+ * Minio Cloud Storage, (C) 2016 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import "testing"
+
+// TestValidateObjectName covers the portability rules added on top of
+// plain UTF-8/length validation: control characters, ".."/"."
+// components, trailing dots/spaces, Windows-reserved device names,
+// and non-NFC Unicode.
+func TestValidateObjectName(t *testing.T) {
+	testCases := []struct {
+		object string
+		valid  bool
+	}{
+		// Valid names.
+		{"object", true},
+		{"path/to/object", true},
+		{"日本語.txt", true},
+		{"object.with.dots", true},
+		{"photos/", true}, // S3 folder-marker convention.
+
+		// Invalid - length/UTF-8 (delegated to isValidPath).
+		{"", false},
+		{string([]byte{0xff, 0xfe}), false},
+
+		// Invalid - control characters.
+		{"object\x00name", false},
+		{"object\x1fname", false},
+		{"object\x7fname", false},
+
+		// Invalid - reserved relative components.
+		{"a/./b", false},
+		{"a/../b", false},
+		{"..", false},
+		{".", false},
+		{"a//b", false}, // empty interior component, not a folder marker.
+
+		// Invalid - trailing dot or space on a component.
+		{"object.", false},
+		{"object ", false},
+		{"dir./object", false},
+
+		// Invalid - Windows-reserved device names, with or without
+		// an extension, case-insensitively.
+		{"CON", false},
+		{"con", false},
+		{"NUL.txt", false},
+		{"path/COM1/object", false},
+		{"LPT9", false},
+
+		// Invalid - NFC vs NFD of the same visual string differ.
+		{"é", false}, // "é" as e + combining acute accent (NFD)
+	}
+	for i, testCase := range testCases {
+		if result := validateObjectName(testCase.object); result != testCase.valid {
+			t.Errorf("Test %d: validateObjectName(%q) = %v, want %v", i, testCase.object, result, testCase.valid)
+		}
+	}
+}
+
+// TestValidateBucketName covers the same portability rules applied to
+// volume/bucket names, which additionally may not contain '/'.
+func TestValidateBucketName(t *testing.T) {
+	testCases := []struct {
+		bucket string
+		valid  bool
+	}{
+		{"bucket", true},
+		{"my-bucket-1", true},
+
+		{"ab", false},         // too short.
+		{"a/b", false},        // no '/' allowed.
+		{"CON", false},        // reserved name.
+		{"bucket.", false},    // trailing dot.
+		{"bucket\x00", false}, // control character.
+		{"école", false},     // NFD, differs under NFC.
+	}
+	for i, testCase := range testCases {
+		if result := validateBucketName(testCase.bucket); result != testCase.valid {
+			t.Errorf("Test %d: validateBucketName(%q) = %v, want %v", i, testCase.bucket, result, testCase.valid)
+		}
+	}
+}